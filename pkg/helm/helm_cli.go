@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/jenkins-x/jx/pkg/helm/resolver"
+	"github.com/jenkins-x/jx/pkg/helm/vendor"
 	"github.com/jenkins-x/jx/pkg/util"
 	"github.com/pkg/errors"
 )
@@ -17,6 +19,10 @@ type HelmCLI struct {
 	BinVersion Version
 	CWD        string
 	Runner     *util.Command
+	// Resolver, when set, is used to resolve chart references that are not a
+	// pre-registered repo alias - a full URL or an `oci://` reference -
+	// before InstallChart/UpgradeChart shell out to the helm binary
+	Resolver *resolver.Resolver
 }
 
 // NewHelmCLI creates a new HelmCLI instance configured to used the provided helm CLI in
@@ -176,6 +182,10 @@ func (h *HelmCLI) BuildDependency() error {
 // InstallChart installs a helm chart according with the given flags
 func (h *HelmCLI) InstallChart(chart string, releaseName string, ns string, version *string, timeout *int,
 	values []string, valueFiles []string) error {
+	chart, version, err := h.resolveChart(chart, version)
+	if err != nil {
+		return err
+	}
 	args := []string{}
 	args = append(args, "install", "--name", releaseName, "--namespace", ns, chart)
 	if timeout != nil {
@@ -196,6 +206,10 @@ func (h *HelmCLI) InstallChart(chart string, releaseName string, ns string, vers
 // UpgradeChart upgrades a helm chart according with given helm flags
 func (h *HelmCLI) UpgradeChart(chart string, releaseName string, ns string, version *string, install bool,
 	timeout *int, force bool, wait bool, values []string, valueFiles []string) error {
+	chart, version, err := h.resolveChart(chart, version)
+	if err != nil {
+		return err
+	}
 	args := []string{}
 	args = append(args, "upgrade")
 	args = append(args, "--namespace", ns)
@@ -297,6 +311,33 @@ func (h *HelmCLI) StatusRelease(releaseName string) error {
 	return h.runHelm("status", releaseName)
 }
 
+// RenderChart dry-run installs/upgrades the given release and returns the
+// manifest it would apply, without actually applying it to the cluster
+func (h *HelmCLI) RenderChart(chart string, releaseName string, ns string, version *string, values []string,
+	valueFiles []string) (string, error) {
+	chart, version, err := h.resolveChart(chart, version)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"upgrade", "--install", "--dry-run", "--namespace", ns}
+	if version != nil {
+		args = append(args, "--version", *version)
+	}
+	for _, value := range values {
+		args = append(args, "--set", value)
+	}
+	for _, valueFile := range valueFiles {
+		args = append(args, "--values", valueFile)
+	}
+	args = append(args, releaseName, chart)
+	return h.runHelmWithOutput(args...)
+}
+
+// GetManifest returns the manifest of the currently deployed release
+func (h *HelmCLI) GetManifest(releaseName string) (string, error) {
+	return h.runHelmWithOutput("get", "manifest", releaseName)
+}
+
 // StatusReleases returns the status of all installed releases
 func (h *HelmCLI) StatusReleases() (map[string]string, error) {
 	output, err := h.ListCharts()
@@ -335,3 +376,39 @@ func (h *HelmCLI) Version(tls bool) (string, error) {
 func (h *HelmCLI) PackageChart() error {
 	return h.runHelm("package", h.CWD)
 }
+
+// Vendor resolves the Chartfile in the current working directory and
+// downloads the charts it requires into a local charts/ directory,
+// recording the resolved versions and digests in Chartfile.lock
+func (h *HelmCLI) Vendor() error {
+	return vendor.NewVendorer(h.CWD).Vendor()
+}
+
+// resolveChart routes chart through h.Resolver when one is configured and
+// chart isn't a plain `repo/name` reference that helm itself already knows
+// how to install, so URLs and `oci://` references work without a prior
+// `helm repo add`. When no Resolver is set, or chart/version don't need
+// resolving, they are returned unchanged
+func (h *HelmCLI) resolveChart(chart string, version *string) (string, *string, error) {
+	if h.Resolver == nil || !isResolvableRef(chart) {
+		return chart, version, nil
+	}
+	constraint := ""
+	if version != nil {
+		constraint = *version
+	}
+	path, resolvedVersion, err := h.Resolver.Resolve(chart, constraint)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to resolve chart reference '%s'", chart)
+	}
+	return path, &resolvedVersion, nil
+}
+
+// isResolvableRef reports whether chart is a reference the resolver knows
+// how to handle directly, rather than a `repo/name` alias that the helm
+// binary itself can already resolve via its registered repositories
+func isResolvableRef(chart string) bool {
+	return strings.HasPrefix(chart, "oci://") ||
+		strings.HasPrefix(chart, "http://") ||
+		strings.HasPrefix(chart, "https://")
+}
@@ -0,0 +1,24 @@
+package helm
+
+// Backend identifies which implementation of the helm actions should be used
+type Backend string
+
+const (
+	// BackendCLI drives helm by shelling out to the `helm` binary
+	BackendCLI Backend = "cli"
+	// BackendSDK drives helm using the Helm Go client libraries directly
+	BackendSDK Backend = "sdk"
+)
+
+// NewHelm creates a new helm client using the requested backend. Existing
+// callers that only know about HelmCLI are unaffected as BackendCLI remains
+// the default when an empty Backend is supplied
+func NewHelm(backend Backend, binary string, version Version, cwd string, repositoryConfig string,
+	repositoryCache string, args ...string) Helmer {
+	switch backend {
+	case BackendSDK:
+		return NewHelmSDK(cwd, repositoryConfig, repositoryCache)
+	default:
+		return NewHelmCLI(binary, version, cwd, args...)
+	}
+}
@@ -0,0 +1,552 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/jenkins-x/jx/pkg/util"
+	"github.com/pkg/errors"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/lint/rules"
+	"k8s.io/helm/pkg/lint/support"
+	hapichart "k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/strvals"
+)
+
+// HelmSDK implements common helm actions using the Helm Go client libraries
+// directly rather than shelling out to the `helm` binary
+type HelmSDK struct {
+	CWD              string
+	TillerNamespace  string
+	RepositoryConfig string
+	RepositoryCache  string
+	Getters          getter.Providers
+}
+
+// NewHelmSDK creates a new HelmSDK instance configured to use the Helm Go
+// client libraries in the given current working directory
+func NewHelmSDK(cwd string, repositoryConfig string, repositoryCache string) *HelmSDK {
+	return &HelmSDK{
+		CWD:              cwd,
+		RepositoryConfig: repositoryConfig,
+		RepositoryCache:  repositoryCache,
+		Getters:          getter.All(environmentSettings(repositoryConfig, repositoryCache)),
+	}
+}
+
+// environmentSettings builds the helm environment settings struct used by
+// the getter/repo packages to locate repositories.yaml and the repo cache
+func environmentSettings(repositoryConfig string, repositoryCache string) environment.EnvSettings {
+	return environment.EnvSettings{
+		Home: helmpath.Home(filepath.Dir(repositoryConfig)),
+	}
+}
+
+// SetCWD configures the common working directory of the SDK client
+func (h *HelmSDK) SetCWD(dir string) {
+	h.CWD = dir
+}
+
+// HelmBinary returns an empty string as the SDK backend does not shell out
+// to a helm binary
+func (h *HelmSDK) HelmBinary() string {
+	return ""
+}
+
+// SetHelmBinary is a no-op for the SDK backend, kept to satisfy the common
+// helm interface
+func (h *HelmSDK) SetHelmBinary(binary string) {
+}
+
+// Init does not install tiller for the SDK backend; it only records
+// tillerNamespace, which newHelmClient uses to locate tiller
+func (h *HelmSDK) Init(clientOnly bool, serviceAccount string, tillerNamespace string, upgrade bool) error {
+	h.TillerNamespace = tillerNamespace
+	return nil
+}
+
+// loadRepoFile loads the repositories.yaml file, returning an empty RepoFile
+// if it does not yet exist
+func (h *HelmSDK) loadRepoFile() (*repo.RepoFile, error) {
+	f := repo.NewRepoFile()
+	exists, err := util.FileExists(h.RepositoryConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "checking for repository config '%s'", h.RepositoryConfig)
+	}
+	if !exists {
+		return f, nil
+	}
+	f, err = repo.LoadFile(h.RepositoryConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load repository config '%s'", h.RepositoryConfig)
+	}
+	return f, nil
+}
+
+// AddRepo adds a new helm repo with the given name and URL, downloading and
+// caching its index file
+func (h *HelmSDK) AddRepo(name string, URL string) error {
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+	entry := &repo.Entry{
+		Name:  name,
+		URL:   URL,
+		Cache: filepath.Join(h.RepositoryCache, name+"-index.yaml"),
+	}
+	chartRepo, err := repo.NewChartRepository(entry, h.Getters)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create chart repository '%s'", name)
+	}
+	if err := chartRepo.DownloadIndexFile(h.RepositoryCache); err != nil {
+		return errors.Wrapf(err, "failed to download index file for repository '%s'", name)
+	}
+	f.Update(entry)
+	return errors.Wrap(f.WriteFile(h.RepositoryConfig, 0644), "failed to write repository config")
+}
+
+// RemoveRepo removes the given repo from the repository config
+func (h *HelmSDK) RemoveRepo(name string) error {
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+	if !f.Remove(name) {
+		return errors.Errorf("repository '%s' not found", name)
+	}
+	return errors.Wrap(f.WriteFile(h.RepositoryConfig, 0644), "failed to write repository config")
+}
+
+// ListRepos list the installed helm repos together with their URL
+func (h *HelmSDK) ListRepos() (map[string]string, error) {
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list repositories")
+	}
+	repos := map[string]string{}
+	for _, entry := range f.Repositories {
+		repos[entry.Name] = entry.URL
+	}
+	return repos, nil
+}
+
+// IsRepoMissing checks if the repository with the given URL is missing from
+// the repository config
+func (h *HelmSDK) IsRepoMissing(URL string) (bool, error) {
+	repos, err := h.ListRepos()
+	if err != nil {
+		return true, errors.Wrap(err, "failed to list the repositories")
+	}
+	for _, repoURL := range repos {
+		if repoURL == URL {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// UpdateRepo downloads a fresh copy of the index file for every configured
+// repository
+func (h *HelmSDK) UpdateRepo() error {
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+	for _, entry := range f.Repositories {
+		chartRepo, err := repo.NewChartRepository(entry, h.Getters)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create chart repository '%s'", entry.Name)
+		}
+		if err := chartRepo.DownloadIndexFile(h.RepositoryCache); err != nil {
+			return errors.Wrapf(err, "failed to update index file for repository '%s'", entry.Name)
+		}
+	}
+	return nil
+}
+
+// RemoveRequirementsLock removes the requirements.lock file from the current
+// working directory
+func (h *HelmSDK) RemoveRequirementsLock() error {
+	dir := h.CWD
+	path := filepath.Join(dir, "requirements.lock")
+	exists, err := util.FileExists(path)
+	if err != nil {
+		return errors.Wrapf(err, "no requirements.lock file found in directory '%s'", dir)
+	}
+	if exists {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrap(err, "failed to remove the requirements.lock file")
+		}
+	}
+	return nil
+}
+
+// BuildDependency resolves and downloads the chart dependencies declared in
+// requirements.yaml using the Helm downloader manager
+func (h *HelmSDK) BuildDependency() error {
+	man := &downloader.Manager{
+		Out:       ioutil.Discard,
+		ChartPath: h.CWD,
+		HelmHome:  helmpath.Home(filepath.Dir(h.RepositoryConfig)),
+		Getters:   h.Getters,
+	}
+	return errors.Wrap(man.Build(), "failed to build chart dependencies")
+}
+
+// locateAndLoadChart resolves chart to a loaded *chart.Chart. chart may be a
+// path to a local directory or packaged .tgz, or a `repo/name` reference
+// that is downloaded via the configured repositories, pinned to version when
+// given, mirroring the repository/getter setup BuildDependency already uses
+func (h *HelmSDK) locateAndLoadChart(chart string, version *string) (*hapichart.Chart, error) {
+	exists, err := util.FileExists(chart)
+	if err == nil && exists {
+		return chartutil.Load(chart)
+	}
+
+	constraint := ""
+	if version != nil {
+		constraint = *version
+	}
+
+	dl := downloader.ChartDownloader{
+		Out:      ioutil.Discard,
+		HelmHome: helmpath.Home(filepath.Dir(h.RepositoryConfig)),
+		Getters:  h.Getters,
+	}
+	if err := os.MkdirAll(h.CWD, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create chart download directory '%s'", h.CWD)
+	}
+	path, _, err := dl.DownloadTo(chart, constraint, h.CWD)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download chart '%s'", chart)
+	}
+	return chartutil.Load(path)
+}
+
+// InstallChart installs a helm chart according with the given flags using
+// the Helm client library
+func (h *HelmSDK) InstallChart(chart string, releaseName string, ns string, version *string, timeout *int,
+	values []string, valueFiles []string) error {
+	chartRequested, err := h.locateAndLoadChart(chart, version)
+	if err != nil {
+		return err
+	}
+	rawValues, err := mergeValues(values, valueFiles)
+	if err != nil {
+		return err
+	}
+	client, err := h.newHelmClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.InstallReleaseFromChart(chartRequested, ns,
+		helm.ReleaseName(releaseName),
+		helm.InstallTimeout(timeoutSeconds(timeout)),
+		helm.ValueOverrides(rawValues))
+	return errors.Wrapf(err, "failed to install chart '%s' as release '%s'", chart, releaseName)
+}
+
+// UpgradeChart upgrades a helm chart according with given helm flags using
+// the Helm client library. When install is true and the release does not
+// exist yet, it is installed instead
+func (h *HelmSDK) UpgradeChart(chart string, releaseName string, ns string, version *string, install bool,
+	timeout *int, force bool, wait bool, values []string, valueFiles []string) error {
+	chartRequested, err := h.locateAndLoadChart(chart, version)
+	if err != nil {
+		return err
+	}
+	rawValues, err := mergeValues(values, valueFiles)
+	if err != nil {
+		return err
+	}
+	client, err := h.newHelmClient()
+	if err != nil {
+		return err
+	}
+	if install {
+		if _, statusErr := client.ReleaseStatus(releaseName); statusErr != nil {
+			if !strings.Contains(statusErr.Error(), "not found") {
+				return errors.Wrapf(statusErr, "failed to get status of release '%s'", releaseName)
+			}
+			_, err = client.InstallReleaseFromChart(chartRequested, ns,
+				helm.ReleaseName(releaseName),
+				helm.InstallTimeout(timeoutSeconds(timeout)),
+				helm.InstallWait(wait),
+				helm.ValueOverrides(rawValues))
+			return errors.Wrapf(err, "failed to install chart '%s' as release '%s'", chart, releaseName)
+		}
+	}
+	_, err = client.UpdateReleaseFromChart(releaseName, chartRequested,
+		helm.UpdateValueOverrides(rawValues),
+		helm.UpgradeTimeout(timeoutSeconds(timeout)),
+		helm.UpgradeForce(force),
+		helm.UpgradeWait(wait))
+	return errors.Wrapf(err, "failed to upgrade release '%s' with chart '%s'", releaseName, chart)
+}
+
+// DeleteRelease removes the given release
+func (h *HelmSDK) DeleteRelease(releaseName string, purge bool) error {
+	client, err := h.newHelmClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteRelease(releaseName, helm.DeletePurge(purge))
+	return errors.Wrapf(err, "failed to delete release '%s'", releaseName)
+}
+
+// ListCharts returns a human readable summary of the installed releases,
+// kept for compatibility with callers of the CLI backend
+func (h *HelmSDK) ListCharts() (string, error) {
+	client, err := h.newHelmClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.ListReleases()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list the installed chart releases")
+	}
+	lines := []string{"NAME\tREVISION\tUPDATED\tSTATUS\tCHART\tNAMESPACE"}
+	for _, rel := range resp.GetReleases() {
+		lines = append(lines, strings.Join([]string{
+			rel.Name,
+			strconv.Itoa(int(rel.Version)),
+			rel.Info.LastDeployed.String(),
+			rel.Info.Status.Code.String(),
+			rel.Chart.Metadata.Name + "-" + rel.Chart.Metadata.Version,
+			rel.Namespace,
+		}, "\t"))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// SearchChartVersions searches the cached index files of every configured
+// repository for the available versions of the given chart
+func (h *HelmSDK) SearchChartVersions(chart string) ([]string, error) {
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	name := chart
+	repoPart := ""
+	if idx := strings.Index(chart, "/"); idx >= 0 {
+		repoPart = chart[:idx]
+		name = chart[idx+1:]
+	}
+	for _, entry := range f.Repositories {
+		if repoPart != "" && entry.Name != repoPart {
+			continue
+		}
+		indexFile, err := repo.LoadIndexFile(entry.Cache)
+		if err != nil {
+			continue
+		}
+		chartVersions, ok := indexFile.Entries[name]
+		if !ok {
+			continue
+		}
+		for _, cv := range chartVersions {
+			versions = append(versions, cv.Version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, errors.Errorf("failed to search chart '%s'", chart)
+	}
+	return versions, nil
+}
+
+// FindChart finds a chart in the current working directory, if no chart file
+// is found an error is returned
+func (h *HelmSDK) FindChart() (string, error) {
+	dir := h.CWD
+	chartFile := filepath.Join(dir, "Chart.yaml")
+	exists, err := util.FileExists(chartFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "no Chart.yaml file found in directory '%s'", dir)
+	}
+	if !exists {
+		files, err := filepath.Glob(filepath.Join(dir, "*/Chart.yaml"))
+		if err != nil {
+			return "", errors.Wrap(err, "no Chart.yaml file found")
+		}
+		if len(files) > 0 {
+			return files[0], nil
+		}
+	}
+	return chartFile, nil
+}
+
+// StatusRelease returns an error if the status of the given release cannot
+// be fetched, e.g. because it does not exist; the release's state itself is
+// not checked
+func (h *HelmSDK) StatusRelease(releaseName string) error {
+	client, err := h.newHelmClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.ReleaseStatus(releaseName)
+	return errors.Wrapf(err, "failed to get status of release '%s'", releaseName)
+}
+
+// StatusReleases returns the status of all installed releases
+func (h *HelmSDK) StatusReleases() (map[string]string, error) {
+	client, err := h.newHelmClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.ListReleases()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the installed chart releases")
+	}
+	statusMap := map[string]string{}
+	for _, rel := range resp.GetReleases() {
+		statusMap[rel.Name] = rel.Info.Status.Code.String()
+	}
+	return statusMap, nil
+}
+
+// RenderChart dry-run installs/upgrades the given release and returns the
+// manifest it would apply, without actually applying it to the cluster
+func (h *HelmSDK) RenderChart(chart string, releaseName string, ns string, version *string, values []string,
+	valueFiles []string) (string, error) {
+	chartRequested, err := h.locateAndLoadChart(chart, version)
+	if err != nil {
+		return "", err
+	}
+	rawValues, err := mergeValues(values, valueFiles)
+	if err != nil {
+		return "", err
+	}
+	client, err := h.newHelmClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.InstallReleaseFromChart(chartRequested, ns,
+		helm.ReleaseName(releaseName),
+		helm.InstallDryRun(true),
+		helm.ValueOverrides(rawValues))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render chart '%s' as release '%s'", chart, releaseName)
+	}
+	return resp.GetRelease().GetManifest(), nil
+}
+
+// GetManifest returns the manifest of the currently deployed release
+func (h *HelmSDK) GetManifest(releaseName string) (string, error) {
+	client, err := h.newHelmClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.ReleaseContent(releaseName)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get manifest of release '%s'", releaseName)
+	}
+	return resp.GetRelease().GetManifest(), nil
+}
+
+// Lint lints the helm chart from the current working directory and returns
+// the warnings in the output
+func (h *HelmSDK) Lint() (string, error) {
+	linter := support.Linter{ChartDir: h.CWD}
+	lintChart(&linter)
+	messages := []string{}
+	for _, msg := range linter.Messages {
+		messages = append(messages, msg.Error())
+	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// Version returns the version of the Helm client library in use
+func (h *HelmSDK) Version(tls bool) (string, error) {
+	return helm.Version, nil
+}
+
+// PackageChart packages the chart from the current working directory
+func (h *HelmSDK) PackageChart() error {
+	chrt, err := chartutil.LoadDir(h.CWD)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load chart from '%s'", h.CWD)
+	}
+	_, err = chartutil.Save(chrt, h.CWD)
+	return errors.Wrapf(err, "failed to package chart from '%s'", h.CWD)
+}
+
+func timeoutSeconds(timeout *int) int64 {
+	if timeout == nil {
+		return 300
+	}
+	return int64(*timeout)
+}
+
+// mergeValues merges the given --values files and --set values, in the same
+// precedence order as the helm CLI, and returns the result as raw YAML
+func mergeValues(values []string, valueFiles []string) ([]byte, error) {
+	base := map[string]interface{}{}
+	for _, path := range valueFiles {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read values file '%s'", path)
+		}
+		current := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &current); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse values file '%s'", path)
+		}
+		base = mergeMaps(base, current)
+	}
+	for _, value := range values {
+		if err := strvals.ParseInto(value, base); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse --set value '%s'", value)
+		}
+	}
+	return yaml.Marshal(base)
+}
+
+// mergeMaps recursively merges src into dest, mirroring Helm's own
+// chartutil mergeValues: a key whose value is a nested map in both dest and
+// src is merged key-by-key rather than overwritten wholesale, so later
+// values files only override the specific nested keys they set
+func mergeMaps(dest map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for k, srcVal := range src {
+		if destMap, ok := dest[k].(map[string]interface{}); ok {
+			if srcMap, ok := srcVal.(map[string]interface{}); ok {
+				dest[k] = mergeMaps(destMap, srcMap)
+				continue
+			}
+		}
+		dest[k] = srcVal
+	}
+	return dest
+}
+
+// lintChart runs the default set of helm lint rules against the chart
+func lintChart(linter *support.Linter) {
+	rules.AllRules(linter)
+}
+
+// newHelmClient connects to the tiller instance running in TillerNamespace.
+// HELM_HOST, when set, always wins (e.g. a port-forward set up by the
+// caller); otherwise we fall back to tiller's in-cluster Service DNS name in
+// TillerNamespace, which only resolves when running inside the cluster
+func (h *HelmSDK) newHelmClient() (*helm.Client, error) {
+	host := os.Getenv("HELM_HOST")
+	if host == "" {
+		if h.TillerNamespace == "" {
+			return nil, errors.New(
+				"no tiller host configured; set HELM_HOST, or call Init with a tillerNamespace")
+		}
+		host = fmt.Sprintf("tiller-deploy.%s.svc:44134", h.TillerNamespace)
+	}
+	return helm.NewClient(helm.Host(host)), nil
+}
@@ -0,0 +1,87 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestMergeValuesDeepMergesNestedMaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-sdk-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	aPath := filepath.Join(dir, "a.yaml")
+	bPath := filepath.Join(dir, "b.yaml")
+	if err := ioutil.WriteFile(aPath, []byte("resources:\n  limits:\n    cpu: 100m\n"), 0644); err != nil {
+		t.Fatalf("failed to write '%s': %v", aPath, err)
+	}
+	if err := ioutil.WriteFile(bPath, []byte("resources:\n  requests:\n    cpu: 50m\n"), 0644); err != nil {
+		t.Fatalf("failed to write '%s': %v", bPath, err)
+	}
+
+	out, err := mergeValues(nil, []string{aPath, bPath})
+	if err != nil {
+		t.Fatalf("mergeValues returned error: %v", err)
+	}
+
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &merged); err != nil {
+		t.Fatalf("failed to parse merged values: %v", err)
+	}
+	resources, ok := merged["resources"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected 'resources' to be a map, got %#v", merged["resources"])
+	}
+	if _, ok := resources["limits"]; !ok {
+		t.Fatalf("expected 'resources.limits' from a.yaml to survive the merge, got %#v", resources)
+	}
+	if _, ok := resources["requests"]; !ok {
+		t.Fatalf("expected 'resources.requests' from b.yaml to survive the merge, got %#v", resources)
+	}
+}
+
+func TestMergeValuesSetOverridesValuesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-sdk-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "values.yaml")
+	if err := ioutil.WriteFile(path, []byte("replicaCount: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write '%s': %v", path, err)
+	}
+
+	out, err := mergeValues([]string{"replicaCount=3"}, []string{path})
+	if err != nil {
+		t.Fatalf("mergeValues returned error: %v", err)
+	}
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &merged); err != nil {
+		t.Fatalf("failed to parse merged values: %v", err)
+	}
+	// ghodss/yaml decodes via JSON, so numbers come back as float64
+	if merged["replicaCount"] != float64(3) {
+		t.Fatalf("expected --set to override values file, got replicaCount=%#v", merged["replicaCount"])
+	}
+}
+
+func TestMergeMapsOverwritesNonMapValues(t *testing.T) {
+	dest := map[string]interface{}{"image": map[string]interface{}{"tag": "1.0", "repo": "foo"}}
+	src := map[string]interface{}{"image": map[string]interface{}{"tag": "2.0"}}
+
+	merged := mergeMaps(dest, src)
+	image := merged["image"].(map[string]interface{})
+	if image["tag"] != "2.0" {
+		t.Fatalf("expected src's 'image.tag' to win, got %#v", image["tag"])
+	}
+	if image["repo"] != "foo" {
+		t.Fatalf("expected dest's 'image.repo' to survive the merge, got %#v", image["repo"])
+	}
+}
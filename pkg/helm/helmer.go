@@ -0,0 +1,33 @@
+package helm
+
+// Helmer is the common set of helm actions implemented by both HelmCLI and
+// HelmSDK, so callers can be written against either backend
+type Helmer interface {
+	SetCWD(dir string)
+	HelmBinary() string
+	SetHelmBinary(binary string)
+	Init(clientOnly bool, serviceAccount string, tillerNamespace string, upgrade bool) error
+	AddRepo(repo string, URL string) error
+	RemoveRepo(repo string) error
+	ListRepos() (map[string]string, error)
+	IsRepoMissing(URL string) (bool, error)
+	UpdateRepo() error
+	RemoveRequirementsLock() error
+	BuildDependency() error
+	InstallChart(chart string, releaseName string, ns string, version *string, timeout *int,
+		values []string, valueFiles []string) error
+	UpgradeChart(chart string, releaseName string, ns string, version *string, install bool,
+		timeout *int, force bool, wait bool, values []string, valueFiles []string) error
+	DeleteRelease(releaseName string, purge bool) error
+	ListCharts() (string, error)
+	SearchChartVersions(chart string) ([]string, error)
+	FindChart() (string, error)
+	StatusRelease(releaseName string) error
+	StatusReleases() (map[string]string, error)
+	RenderChart(chart string, releaseName string, ns string, version *string, values []string,
+		valueFiles []string) (string, error)
+	GetManifest(releaseName string) (string, error)
+	Lint() (string, error)
+	Version(tls bool) (string, error)
+	PackageChart() error
+}
@@ -0,0 +1,393 @@
+package resolver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+// helmChartLayerMediaType identifies the OCI manifest layer that holds the
+// chart tarball, per Helm's OCI support (helm.sh/helm push/pull)
+const helmChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// defaultCacheDir is where downloaded chart tarballs are cached, keyed by
+// their SHA256 digest, when no CacheDir is explicitly configured
+const defaultCacheDir = ".jx/cache/charts"
+
+// HostAuth holds the optional credentials used when talking to a chart
+// repository host
+type HostAuth struct {
+	Username string
+	Password string
+	Bearer   string
+}
+
+// Resolver resolves a chart reference - a `repo/name` alias, a full chart
+// tarball URL, or an `oci://` reference - to a downloaded tarball on disk, without
+// requiring the repository to be registered via `helm repo add` first
+type Resolver struct {
+	// CacheDir is where downloaded tarballs are cached by digest
+	CacheDir string
+	// Repos maps a short repo alias (as used in `repo/name` references) to
+	// its index URL
+	Repos map[string]string
+	// Auth maps a host name to the credentials to use against it
+	Auth map[string]HostAuth
+
+	httpClient *http.Client
+}
+
+// NewResolver creates a Resolver that caches tarballs under ~/.jx/cache/charts
+func NewResolver(repos map[string]string) (*Resolver, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve the user's home directory")
+	}
+	return &Resolver{
+		CacheDir:   filepath.Join(home, defaultCacheDir),
+		Repos:      repos,
+		Auth:       map[string]HostAuth{},
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+// Resolve resolves ref to a local chart tarball path and the version that
+// was actually selected. ref may be:
+//   - `repo/chart` where repo is a known alias from Resolver.Repos
+//   - a full HTTP(S) URL pointing directly at a chart tarball (.tgz)
+//   - an `oci://registry/repository:tag` reference
+//
+// For a `repo/chart` reference, versionConstraint is a semver constraint
+// such as `^1.2` or `>=1.0 <2.0`, and an empty constraint matches the newest
+// available version. For a tarball URL versionConstraint is ignored. For an
+// `oci://` reference the reference's own tag is used, or else
+// versionConstraint taken literally as the tag; with neither, an error is
+// returned
+func (r *Resolver) Resolve(ref string, versionConstraint string) (chartPath string, resolvedVersion string, err error) {
+	switch {
+	case strings.HasPrefix(ref, "oci://"):
+		return r.resolveOCI(ref, versionConstraint)
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		return r.resolveTarballURL(ref)
+	default:
+		parts := strings.SplitN(ref, "/", 2)
+		if len(parts) != 2 {
+			return "", "", errors.Errorf("invalid chart reference '%s', expected 'repo/chart'", ref)
+		}
+		repoURL, ok := r.Repos[parts[0]]
+		if !ok {
+			return "", "", errors.Errorf("unknown repository alias '%s' in reference '%s'", parts[0], ref)
+		}
+		return r.resolveIndexEntry(repoURL, parts[1], versionConstraint)
+	}
+}
+
+// resolveIndexEntry downloads repoURL/index.yaml, selects the best version
+// of chart matching versionConstraint and downloads its tarball
+func (r *Resolver) resolveIndexEntry(repoURL string, chart string, versionConstraint string) (string, string, error) {
+	indexURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+	data, err := r.get(indexURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download repository index '%s'", indexURL)
+	}
+
+	idx := &index{}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return "", "", errors.Wrapf(err, "failed to parse repository index '%s'", indexURL)
+	}
+	versions, ok := idx.Entries[chart]
+	if !ok {
+		return "", "", errors.Errorf("chart '%s' not found in repository index '%s'", chart, indexURL)
+	}
+
+	entry, err := selectVersion(versions, versionConstraint)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to resolve chart '%s' version '%s'", chart, versionConstraint)
+	}
+	if len(entry.URLs) == 0 {
+		return "", "", errors.Errorf("chart '%s' version '%s' has no download URL", chart, entry.Version)
+	}
+
+	tarballURL, err := resolveReferenceURL(repoURL, entry.URLs[0])
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to resolve tarball URL '%s' against repository '%s'",
+			entry.URLs[0], repoURL)
+	}
+	path, err := r.download(tarballURL)
+	if err != nil {
+		return "", "", err
+	}
+	return path, entry.Version, nil
+}
+
+// resolveReferenceURL resolves a chart index entry's URL against the
+// repository's own base URL, mirroring Helm's repo.ResolveReferenceURL: an
+// index.yaml is allowed to list tarball URLs relative to the repo, not just
+// absolute ones
+func resolveReferenceURL(baseURL string, refURL string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid repository URL '%s'", baseURL)
+	}
+	ref, err := url.Parse(refURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid tarball URL '%s'", refURL)
+	}
+	if ref.IsAbs() {
+		return refURL, nil
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path = base.Path + "/"
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// resolveTarballURL downloads ref directly as a chart tarball. There is no
+// index.yaml fetch here: a bare base URL carries no chart name to look up,
+// so callers must pass the tarball URL itself
+func (r *Resolver) resolveTarballURL(ref string) (string, string, error) {
+	if !strings.HasSuffix(ref, ".tgz") && !strings.HasSuffix(ref, ".tar.gz") {
+		return "", "", errors.Errorf(
+			"HTTP(S) chart references must point directly at a chart tarball (.tgz), got '%s'", ref)
+	}
+	path, err := r.download(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return path, versionFromTarballName(ref), nil
+}
+
+// versionFromTarballName extracts the version suffix from a standard helm
+// tarball file name, e.g. `mychart-1.2.3.tgz` -> `1.2.3`
+func versionFromTarballName(ref string) string {
+	base := filepath.Base(ref)
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".tgz"), ".tar.gz")
+	idx := strings.LastIndex(base, "-")
+	if idx < 0 {
+		return ""
+	}
+	return base[idx+1:]
+}
+
+// resolveOCI resolves an `oci://registry/repository[:tag]` reference per the
+// OCI distribution spec: it fetches the manifest for the tag, finds the
+// layer holding the chart content, and downloads that layer by digest
+// (fluxcd/helm-operator's EnsureChartFetched follows the same manifest-then-
+// blob shape for its getter-based chart fetch)
+func (r *Resolver) resolveOCI(ref string, versionConstraint string) (string, string, error) {
+	rest := strings.TrimPrefix(ref, "oci://")
+	registry, repository, ok := splitRegistryRepository(rest)
+	if !ok {
+		return "", "", errors.Errorf("invalid OCI reference '%s', expected 'oci://registry/repository[:tag]'", ref)
+	}
+
+	tag := ""
+	if idx := strings.LastIndex(repository, ":"); idx >= 0 {
+		tag = repository[idx+1:]
+		repository = repository[:idx]
+	}
+	if tag == "" {
+		tag = versionConstraint
+	}
+	if tag == "" {
+		return "", "", errors.Errorf("OCI reference '%s' has no tag and no version constraint was given", ref)
+	}
+
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, tag)
+	digest, err := r.fetchChartLayerDigest(manifestURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to resolve OCI manifest '%s'", manifestURL)
+	}
+
+	blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", registry, repository, digest)
+	path, err := r.download(blobURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to pull OCI chart layer '%s'", blobURL)
+	}
+	return path, tag, nil
+}
+
+// splitRegistryRepository splits `registry/repository...` on its first `/`
+func splitRegistryRepository(ref string) (registry string, repository string, ok bool) {
+	idx := strings.Index(ref, "/")
+	if idx < 0 {
+		return "", "", false
+	}
+	return ref[:idx], ref[idx+1:], true
+}
+
+// fetchChartLayerDigest fetches the OCI manifest at manifestURL and returns
+// the digest of the layer whose media type identifies it as chart content
+func (r *Resolver) fetchChartLayerDigest(manifestURL string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
+	r.applyAuth(req)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return "", errors.Wrap(err, "failed to parse OCI manifest")
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == helmChartLayerMediaType {
+			return layer.Digest, nil
+		}
+	}
+	return "", errors.New("manifest has no helm chart content layer")
+}
+
+// ociManifest is the minimal shape of an OCI image manifest needed to find
+// the chart content layer's digest
+type ociManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// download fetches tarballURL, caches it under CacheDir keyed by its SHA256
+// digest, and verifies its provenance file if one is published alongside it
+func (r *Resolver) download(tarballURL string) (string, error) {
+	data, err := r.get(tarballURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to download chart tarball '%s'", tarballURL)
+	}
+
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	if err := r.verifyProvenance(tarballURL, digest); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(r.CacheDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create cache directory '%s'", r.CacheDir)
+	}
+	path := filepath.Join(r.CacheDir, digest+".tgz")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to cache chart tarball '%s'", path)
+	}
+	return path, nil
+}
+
+// verifyProvenance checks whether a `.prov` file exists alongside
+// tarballURL, and if so verifies it records the digest we just downloaded.
+// A missing provenance file is not an error: it is optional by design
+func (r *Resolver) verifyProvenance(tarballURL string, digest string) error {
+	provURL := tarballURL + ".prov"
+	data, err := r.get(provURL)
+	if err != nil {
+		return nil
+	}
+	if !strings.Contains(string(data), digest) {
+		return errors.Errorf("provenance file '%s' does not match the downloaded chart's digest", provURL)
+	}
+	return nil
+}
+
+// get performs an authenticated GET against url, using any HostAuth
+// registered for the URL's host
+func (r *Resolver) get(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.applyAuth(req)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (r *Resolver) applyAuth(req *http.Request) {
+	auth, ok := r.Auth[req.URL.Host]
+	if !ok {
+		return
+	}
+	if auth.Bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.Bearer)
+		return
+	}
+	if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+}
+
+type index struct {
+	Entries map[string][]indexVersion `json:"entries"`
+}
+
+type indexVersion struct {
+	Version string   `json:"version"`
+	URLs    []string `json:"urls"`
+}
+
+// selectVersion returns the newest version satisfying constraint, or the
+// newest version overall when constraint is empty
+func selectVersion(versions []indexVersion, constraint string) (indexVersion, error) {
+	var constraintExpr *semver.Constraints
+	if constraint != "" {
+		c, err := semver.NewConstraint(constraint)
+		if err != nil {
+			return indexVersion{}, errors.Wrapf(err, "invalid version constraint '%s'", constraint)
+		}
+		constraintExpr = c
+	}
+
+	var best indexVersion
+	var bestVersion *semver.Version
+	for _, v := range versions {
+		parsed, err := semver.NewVersion(v.Version)
+		if err != nil {
+			continue
+		}
+		if constraintExpr != nil && !constraintExpr.Check(parsed) {
+			continue
+		}
+		if bestVersion == nil || parsed.GreaterThan(bestVersion) {
+			best = v
+			bestVersion = parsed
+		}
+	}
+	if bestVersion == nil {
+		return indexVersion{}, errors.Errorf("no version found matching constraint '%s'", constraint)
+	}
+	return best, nil
+}
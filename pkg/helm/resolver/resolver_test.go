@@ -0,0 +1,97 @@
+package resolver
+
+import "testing"
+
+func TestSelectVersionPicksNewestMatchingConstraint(t *testing.T) {
+	versions := []indexVersion{
+		{Version: "1.0.0"},
+		{Version: "1.2.0"},
+		{Version: "2.0.0"},
+	}
+	entry, err := selectVersion(versions, "^1.0")
+	if err != nil {
+		t.Fatalf("selectVersion returned error: %v", err)
+	}
+	if entry.Version != "1.2.0" {
+		t.Fatalf("expected newest version matching '^1.0' to be '1.2.0', got '%s'", entry.Version)
+	}
+}
+
+func TestSelectVersionEmptyConstraintPicksNewestOverall(t *testing.T) {
+	versions := []indexVersion{
+		{Version: "1.0.0"},
+		{Version: "2.0.0"},
+		{Version: "1.5.0"},
+	}
+	entry, err := selectVersion(versions, "")
+	if err != nil {
+		t.Fatalf("selectVersion returned error: %v", err)
+	}
+	if entry.Version != "2.0.0" {
+		t.Fatalf("expected newest version overall to be '2.0.0', got '%s'", entry.Version)
+	}
+}
+
+func TestSelectVersionNoMatchReturnsError(t *testing.T) {
+	versions := []indexVersion{{Version: "1.0.0"}}
+	if _, err := selectVersion(versions, "^2.0"); err == nil {
+		t.Fatal("expected an error when no version satisfies the constraint, got nil")
+	}
+}
+
+func TestVersionFromTarballName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tgz", "mychart-1.2.3.tgz", "1.2.3"},
+		{"tar.gz", "mychart-1.2.3.tar.gz", "1.2.3"},
+		{"no version separator", "mychart.tgz", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := versionFromTarballName(tt.in); got != tt.want {
+				t.Fatalf("versionFromTarballName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRegistryRepository(t *testing.T) {
+	registry, repository, ok := splitRegistryRepository("registry.example.com/foo/bar:1.2.3")
+	if !ok {
+		t.Fatal("expected splitRegistryRepository to succeed")
+	}
+	if registry != "registry.example.com" {
+		t.Fatalf("expected registry 'registry.example.com', got '%s'", registry)
+	}
+	if repository != "foo/bar:1.2.3" {
+		t.Fatalf("expected repository 'foo/bar:1.2.3', got '%s'", repository)
+	}
+
+	if _, _, ok := splitRegistryRepository("no-slash-here"); ok {
+		t.Fatal("expected splitRegistryRepository to fail on a ref with no '/'")
+	}
+}
+
+func TestResolveReferenceURLAbsoluteURLIsUnchanged(t *testing.T) {
+	got, err := resolveReferenceURL("https://charts.example.com/stable", "https://other.example.com/foo-1.0.0.tgz")
+	if err != nil {
+		t.Fatalf("resolveReferenceURL returned error: %v", err)
+	}
+	if got != "https://other.example.com/foo-1.0.0.tgz" {
+		t.Fatalf("expected an absolute URL to pass through unchanged, got '%s'", got)
+	}
+}
+
+func TestResolveReferenceURLRelativeURLIsJoinedToBase(t *testing.T) {
+	got, err := resolveReferenceURL("https://charts.example.com/stable", "charts/foo-1.0.0.tgz")
+	if err != nil {
+		t.Fatalf("resolveReferenceURL returned error: %v", err)
+	}
+	want := "https://charts.example.com/stable/charts/foo-1.0.0.tgz"
+	if got != want {
+		t.Fatalf("resolveReferenceURL(relative) = '%s', want '%s'", got, want)
+	}
+}
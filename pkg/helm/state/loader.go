@@ -0,0 +1,132 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// LoadFile loads a StateSpec from the given YAML file, recursively merging
+// in any `bases:` it declares (resolved relative to the file's own directory)
+// before the file's own releases and environments are applied on top
+func LoadFile(path string) (*StateSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read state file '%s'", path)
+	}
+	spec := &StateSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse state file '%s'", path)
+	}
+
+	merged := &StateSpec{}
+	dir := filepath.Dir(path)
+	for _, base := range spec.Bases {
+		basePath := base
+		if !filepath.IsAbs(basePath) {
+			basePath = filepath.Join(dir, basePath)
+		}
+		baseSpec, err := LoadFile(basePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load base '%s' of state file '%s'", base, path)
+		}
+		merged = mergeState(merged, baseSpec)
+	}
+	return mergeState(merged, spec), nil
+}
+
+// mergeState deep-merges override on top of base: releases are merged by
+// name (override wins field by field), environments are merged by name, and
+// any other scalar fields in override simply replace those in base
+func mergeState(base *StateSpec, override *StateSpec) *StateSpec {
+	result := &StateSpec{
+		Environments: map[string]EnvironmentSpec{},
+	}
+	for name, env := range base.Environments {
+		result.Environments[name] = env
+	}
+	for name, env := range override.Environments {
+		result.Environments[name] = env
+	}
+
+	byName := map[string]ReleaseSpec{}
+	order := []string{}
+	for _, r := range base.Releases {
+		byName[r.Name] = r
+		order = append(order, r.Name)
+	}
+	for _, r := range override.Releases {
+		if _, exists := byName[r.Name]; !exists {
+			order = append(order, r.Name)
+		}
+		byName[r.Name] = mergeRelease(byName[r.Name], r)
+	}
+	for _, name := range order {
+		result.Releases = append(result.Releases, byName[name])
+	}
+	return result
+}
+
+// mergeRelease merges override on top of base, field by field; a zero value
+// in override means "inherit from base"
+func mergeRelease(base ReleaseSpec, override ReleaseSpec) ReleaseSpec {
+	merged := base
+	merged.Name = override.Name
+	if override.Namespace != "" {
+		merged.Namespace = override.Namespace
+	}
+	if override.Chart != "" {
+		merged.Chart = override.Chart
+	}
+	if override.Version != "" {
+		merged.Version = override.Version
+	}
+	if len(override.Values) > 0 {
+		merged.Values = override.Values
+	}
+	if len(override.ValueFiles) > 0 {
+		merged.ValueFiles = override.ValueFiles
+	}
+	if override.Timeout != nil {
+		merged.Timeout = override.Timeout
+	}
+	if override.Wait != nil {
+		merged.Wait = override.Wait
+	}
+	if len(override.Needs) > 0 {
+		merged.Needs = override.Needs
+	}
+	return merged
+}
+
+// RenderValueFile templates the given values file using Go text/template
+// syntax, exposing the active environment's name as `.Environment`, its
+// values as `.Values` and the release itself as `.Release`
+func RenderValueFile(path string, environmentName string, env EnvironmentSpec, release ReleaseSpec) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read values file '%s'", path)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse values file '%s' as a template", path)
+	}
+	data2 := struct {
+		Environment string
+		Values      []string
+		Release     ReleaseSpec
+	}{
+		Environment: environmentName,
+		Values:      env.Values,
+		Release:     release,
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data2); err != nil {
+		return nil, errors.Wrapf(err, "failed to render values file '%s'", path)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,299 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Releaser is the subset of helm.HelmCLI / helm.HelmSDK that the state
+// reconciler needs in order to drive releases; both backends implement it
+type Releaser interface {
+	UpgradeChart(chart string, releaseName string, ns string, version *string, install bool,
+		timeout *int, force bool, wait bool, values []string, valueFiles []string) error
+	DeleteRelease(releaseName string, purge bool) error
+	StatusRelease(releaseName string) error
+	StatusReleases() (map[string]string, error)
+	RenderChart(chart string, releaseName string, ns string, version *string, values []string,
+		valueFiles []string) (string, error)
+	GetManifest(releaseName string) (string, error)
+}
+
+// Driver reconciles a StateSpec against a cluster via a Releaser
+type Driver struct {
+	Releaser    Releaser
+	Environment string
+	Concurrency int
+}
+
+// NewDriver creates a new Driver for the given Releaser, reconciling the
+// named environment with up to `concurrency` releases applied in parallel
+func NewDriver(releaser Releaser, environment string, concurrency int) *Driver {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Driver{
+		Releaser:    releaser,
+		Environment: environment,
+		Concurrency: concurrency,
+	}
+}
+
+// Apply installs or upgrades every release in the spec, honouring `needs:`
+// ordering but without deleting releases no longer present in the spec
+func (d *Driver) Apply(spec *StateSpec) error {
+	return d.sync(spec, false)
+}
+
+// Sync installs/upgrades every release in the spec and additionally removes
+// any release that is no longer declared, topologically ordering installs on
+// `needs:` and running independent releases concurrently via a worker pool
+func (d *Driver) Sync(spec *StateSpec) error {
+	return d.sync(spec, true)
+}
+
+func (d *Driver) sync(spec *StateSpec, prune bool) error {
+	batches, err := topoSortBatches(spec.Releases)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		if err := d.applyBatch(spec, batch); err != nil {
+			return err
+		}
+	}
+
+	if !prune {
+		return nil
+	}
+	return d.pruneRemoved(spec)
+}
+
+// pruneRemoved deletes every currently installed release that is no longer
+// declared in spec
+func (d *Driver) pruneRemoved(spec *StateSpec) error {
+	installed, err := d.Releaser.StatusReleases()
+	if err != nil {
+		return errors.Wrap(err, "failed to list installed releases while pruning")
+	}
+	declared := map[string]bool{}
+	for _, r := range spec.Releases {
+		declared[r.Name] = true
+	}
+	for name := range installed {
+		if declared[name] {
+			continue
+		}
+		if err := d.Releaser.DeleteRelease(name, true); err != nil {
+			return errors.Wrapf(err, "failed to prune release '%s'", name)
+		}
+	}
+	return nil
+}
+
+// applyBatch reconciles a set of releases with no dependencies between them
+// concurrently, bounded by d.Concurrency
+func (d *Driver) applyBatch(spec *StateSpec, batch []ReleaseSpec) error {
+	sem := make(chan struct{}, d.Concurrency)
+	var wg sync.WaitGroup
+	errs := make([]error, len(batch))
+
+	for i, release := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, release ReleaseSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = d.applyRelease(spec, release)
+		}(i, release)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "failed to reconcile release '%s'", batch[i].Name)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) applyRelease(spec *StateSpec, release ReleaseSpec) error {
+	values, valueFiles, cleanup, err := d.resolveValues(spec, release)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	version := &release.Version
+	if release.Version == "" {
+		version = nil
+	}
+	return d.Releaser.UpgradeChart(release.Chart, release.Name, release.Namespace, version, true,
+		release.Timeout, false, release.Wait != nil && *release.Wait, values, valueFiles)
+}
+
+// resolveValues merges the active environment's values with the release's
+// own inline values, and renders each of the release's value files as a Go
+// text/template (exposing the environment and release, see RenderValueFile)
+// to a temp file so the helm client reads the rendered result. The returned
+// cleanup func removes those temp files once the caller is done with them
+// and must always be called, even on error
+func (d *Driver) resolveValues(spec *StateSpec, release ReleaseSpec) (values []string, valueFiles []string, cleanup func(), err error) {
+	env := spec.Environments[d.Environment]
+	values = append(append([]string{}, env.Values...), release.Values...)
+
+	var tempPaths []string
+	cleanup = func() {
+		for _, p := range tempPaths {
+			os.Remove(p)
+		}
+	}
+
+	valueFiles = make([]string, 0, len(release.ValueFiles))
+	for _, path := range release.ValueFiles {
+		rendered, err := RenderValueFile(path, d.Environment, env, release)
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		renderedPath, err := writeTempValues(release.Name, rendered)
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		tempPaths = append(tempPaths, renderedPath)
+		valueFiles = append(valueFiles, renderedPath)
+	}
+	return values, valueFiles, cleanup, nil
+}
+
+// writeTempValues writes the rendered values file content to a temp file so
+// it can be passed to the helm client as a regular --values path
+func writeTempValues(releaseName string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", "state-"+releaseName+"-*.yaml")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create temp values file for release '%s'", releaseName)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", errors.Wrapf(err, "failed to write temp values file for release '%s'", releaseName)
+	}
+	return f.Name(), nil
+}
+
+// Destroy deletes every release declared in the spec, in reverse dependency
+// order so releases are torn down after anything that depends on them
+func (d *Driver) Destroy(spec *StateSpec) error {
+	batches, err := topoSortBatches(spec.Releases)
+	if err != nil {
+		return err
+	}
+	for i := len(batches) - 1; i >= 0; i-- {
+		for _, release := range batches[i] {
+			if err := d.Releaser.DeleteRelease(release.Name, true); err != nil {
+				return errors.Wrapf(err, "failed to delete release '%s'", release.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Diff reports the name of every release that is not yet installed or whose
+// locally rendered manifest differs from the manifest of the currently
+// deployed release
+func (d *Driver) Diff(spec *StateSpec) ([]string, error) {
+	changed := []string{}
+	for _, release := range spec.Releases {
+		diff, err := d.diffRelease(spec, release)
+		if err != nil {
+			return nil, err
+		}
+		if diff {
+			changed = append(changed, release.Name)
+		}
+	}
+	return changed, nil
+}
+
+// diffRelease reports whether release is not yet installed, or is installed
+// but its current manifest no longer matches what would be rendered from
+// the spec
+func (d *Driver) diffRelease(spec *StateSpec, release ReleaseSpec) (bool, error) {
+	current, err := d.Releaser.GetManifest(release.Name)
+	if err != nil {
+		if isReleaseNotFoundErr(err) {
+			return true, nil
+		}
+		return false, errors.Wrapf(err, "failed to get current manifest for release '%s'", release.Name)
+	}
+
+	values, valueFiles, cleanup, err := d.resolveValues(spec, release)
+	defer cleanup()
+	if err != nil {
+		return false, err
+	}
+	version := &release.Version
+	if release.Version == "" {
+		version = nil
+	}
+	rendered, err := d.Releaser.RenderChart(release.Chart, release.Name, release.Namespace, version,
+		values, valueFiles)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to render chart for release '%s'", release.Name)
+	}
+
+	return strings.TrimSpace(rendered) != strings.TrimSpace(current), nil
+}
+
+// isReleaseNotFoundErr reports whether err indicates that a release simply
+// doesn't exist yet, as opposed to some other failure (e.g. a transient
+// tiller/connection error) that callers should not mistake for "needs
+// install". Both HelmCLI and HelmSDK surface this as a "not found" error
+// from the underlying helm client/CLI
+func isReleaseNotFoundErr(err error) bool {
+	return strings.Contains(err.Error(), "not found")
+}
+
+// topoSortBatches orders releases into batches such that every release in a
+// batch only depends on releases from earlier batches, so each batch can be
+// applied concurrently
+func topoSortBatches(releases []ReleaseSpec) ([][]ReleaseSpec, error) {
+	byName := map[string]ReleaseSpec{}
+	for _, r := range releases {
+		byName[r.Name] = r
+	}
+
+	done := map[string]bool{}
+	var batches [][]ReleaseSpec
+
+	for len(done) < len(releases) {
+		var batch []ReleaseSpec
+		for _, r := range releases {
+			if done[r.Name] {
+				continue
+			}
+			ready := true
+			for _, need := range r.Needs {
+				if _, exists := byName[need]; !exists {
+					return nil, errors.Errorf("release '%s' needs unknown release '%s'", r.Name, need)
+				}
+				if !done[need] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				batch = append(batch, r)
+			}
+		}
+		if len(batch) == 0 {
+			return nil, errors.New("circular or unresolvable 'needs' dependency among releases")
+		}
+		for _, r := range batch {
+			done[r.Name] = true
+		}
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
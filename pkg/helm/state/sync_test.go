@@ -0,0 +1,43 @@
+package state
+
+import "testing"
+
+func TestTopoSortBatchesOrdersByNeeds(t *testing.T) {
+	releases := []ReleaseSpec{
+		{Name: "app", Needs: []string{"db"}},
+		{Name: "db"},
+		{Name: "cache"},
+	}
+	batches, err := topoSortBatches(releases)
+	if err != nil {
+		t.Fatalf("topoSortBatches returned error: %v", err)
+	}
+	batchOf := map[string]int{}
+	for i, batch := range batches {
+		for _, r := range batch {
+			batchOf[r.Name] = i
+		}
+	}
+	if batchOf["db"] >= batchOf["app"] {
+		t.Fatalf("expected 'db' batch before 'app' batch, got db=%d app=%d", batchOf["db"], batchOf["app"])
+	}
+}
+
+func TestTopoSortBatchesDetectsCircularDependency(t *testing.T) {
+	releases := []ReleaseSpec{
+		{Name: "a", Needs: []string{"b"}},
+		{Name: "b", Needs: []string{"a"}},
+	}
+	if _, err := topoSortBatches(releases); err == nil {
+		t.Fatal("expected an error for a circular 'needs' dependency, got nil")
+	}
+}
+
+func TestTopoSortBatchesDetectsUnknownDependency(t *testing.T) {
+	releases := []ReleaseSpec{
+		{Name: "a", Needs: []string{"missing"}},
+	}
+	if _, err := topoSortBatches(releases); err == nil {
+		t.Fatal("expected an error for a 'needs' reference to an unknown release, got nil")
+	}
+}
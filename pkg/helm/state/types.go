@@ -0,0 +1,56 @@
+package state
+
+// StateSpec describes the desired set of helm releases that should exist in
+// a cluster, modelled after the helmfile `ReleaseSetSpec` idea
+type StateSpec struct {
+	// Bases lists other state files to deep-merge underneath this one before
+	// it is evaluated, in order, so later bases win over earlier ones
+	Bases []string `json:"bases,omitempty"`
+
+	// Environments declares named sets of layered default values that
+	// Releases can opt into via the active environment
+	Environments map[string]EnvironmentSpec `json:"environments,omitempty"`
+
+	// Releases is the set of releases this state describes
+	Releases []ReleaseSpec `json:"releases"`
+}
+
+// EnvironmentSpec is a named layer of default values applied to every
+// release when that environment is active
+type EnvironmentSpec struct {
+	Values []string `json:"values,omitempty"`
+}
+
+// ReleaseSpec describes a single desired helm release
+type ReleaseSpec struct {
+	// Name is the helm release name
+	Name string `json:"name"`
+
+	// Namespace the release should be installed into
+	Namespace string `json:"namespace"`
+
+	// Chart is the chart reference, e.g. `repo/name`
+	Chart string `json:"chart"`
+
+	// Version is the chart version constraint; empty means latest
+	Version string `json:"version,omitempty"`
+
+	// Values are inline `--set` style overrides
+	Values []string `json:"values,omitempty"`
+
+	// ValueFiles are paths to YAML values files, templated with text/template
+	// over the environment values and the release itself before use
+	ValueFiles []string `json:"valueFiles,omitempty"`
+
+	// Timeout is the number of seconds to wait for the release to become ready
+	Timeout *int `json:"timeout,omitempty"`
+
+	// Wait mirrors `helm upgrade --wait`. A pointer so that a base's `true`
+	// can be overridden back to `false` by a more specific release entry;
+	// nil means "inherit from base", and defaults to false at the root
+	Wait *bool `json:"wait,omitempty"`
+
+	// Needs lists the names of releases that must be reconciled before this
+	// one, expressing an inter-release dependency order
+	Needs []string `json:"needs,omitempty"`
+}
@@ -0,0 +1,116 @@
+package vendor
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// ChartfileName is the default file name of a chart vendoring manifest
+const ChartfileName = "Chartfile"
+
+// LockfileName is the default file name of the resolved vendoring lock file
+const LockfileName = "Chartfile.lock"
+
+// Chartfile declares the set of charts a project vendors into its local
+// `charts/` directory, independently of a chart's own requirements.yaml
+type Chartfile struct {
+	// Repositories lists the named chart repositories that Requires entries
+	// may reference
+	Repositories []RepositorySpec `json:"repositories,omitempty"`
+
+	// Requires lists chart references of the form `repo/chart@version`
+	Requires []string `json:"requires"`
+}
+
+// RepositorySpec is a named chart repository URL
+type RepositorySpec struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Lockfile records the resolved version and digest of every vendored chart,
+// so that subsequent Vendor() calls are reproducible without re-resolving
+// version constraints
+type Lockfile struct {
+	Charts []LockedChart `json:"charts"`
+}
+
+// LockedChart is a single resolved entry in the Chartfile.lock
+type LockedChart struct {
+	Repo    string `json:"repo"`
+	Chart   string `json:"chart"`
+	Version string `json:"version"`
+	Digest  string `json:"digest"`
+}
+
+// LoadChartfile reads and parses the Chartfile at the given path
+func LoadChartfile(path string) (*Chartfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read Chartfile '%s'", path)
+	}
+	cf := &Chartfile{}
+	if err := yaml.Unmarshal(data, cf); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse Chartfile '%s'", path)
+	}
+	return cf, nil
+}
+
+// Save writes the Chartfile back out to the given path
+func (c *Chartfile) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal Chartfile")
+	}
+	return errors.Wrapf(ioutil.WriteFile(path, data, 0644), "failed to write Chartfile '%s'", path)
+}
+
+// LoadLockfile reads and parses the Chartfile.lock at the given path,
+// returning an empty Lockfile if it does not yet exist
+func LoadLockfile(path string) (*Lockfile, error) {
+	lock := &Lockfile{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read lock file '%s'", path)
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse lock file '%s'", path)
+	}
+	return lock, nil
+}
+
+// Save writes the Lockfile back out to the given path
+func (l *Lockfile) Save(path string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal lock file")
+	}
+	return errors.Wrapf(ioutil.WriteFile(path, data, 0644), "failed to write lock file '%s'", path)
+}
+
+// Get returns the locked entry for the given repo/chart, if any
+func (l *Lockfile) Get(repo string, chart string) (LockedChart, bool) {
+	for _, c := range l.Charts {
+		if c.Repo == repo && c.Chart == chart {
+			return c, true
+		}
+	}
+	return LockedChart{}, false
+}
+
+// Set inserts or updates the locked entry for the given repo/chart
+func (l *Lockfile) Set(entry LockedChart) {
+	for i, c := range l.Charts {
+		if c.Repo == entry.Repo && c.Chart == entry.Chart {
+			l.Charts[i] = entry
+			return
+		}
+	}
+	l.Charts = append(l.Charts, entry)
+}
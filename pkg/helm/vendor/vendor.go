@@ -0,0 +1,390 @@
+package vendor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Vendorer downloads the charts declared in a Chartfile into a local
+// `charts/` directory, recording resolved versions and digests in a
+// Chartfile.lock so subsequent runs are reproducible
+type Vendorer struct {
+	// Dir is the directory containing the Chartfile, Chartfile.lock and the
+	// charts/ directory they describe
+	Dir string
+}
+
+// NewVendorer creates a Vendorer rooted at the given directory
+func NewVendorer(dir string) *Vendorer {
+	return &Vendorer{Dir: dir}
+}
+
+func (v *Vendorer) chartfilePath() string {
+	return filepath.Join(v.Dir, ChartfileName)
+}
+
+func (v *Vendorer) lockfilePath() string {
+	return filepath.Join(v.Dir, LockfileName)
+}
+
+func (v *Vendorer) chartsDir() string {
+	return filepath.Join(v.Dir, "charts")
+}
+
+// Vendor resolves every entry in the Chartfile's `requires:` list, downloads
+// its chart tarball into charts/<repo>/<chart>/ and writes the resolved
+// versions and digests out to Chartfile.lock. Entries whose version and
+// digest are already recorded in the lock file are re-downloaded using the
+// locked version, rather than re-resolving the constraint, so that vendoring
+// is reproducible across machines
+func (v *Vendorer) Vendor() error {
+	cf, err := LoadChartfile(v.chartfilePath())
+	if err != nil {
+		return err
+	}
+	lock, err := LoadLockfile(v.lockfilePath())
+	if err != nil {
+		return err
+	}
+
+	repos := map[string]string{}
+	for _, r := range cf.Repositories {
+		repos[r.Name] = r.URL
+	}
+
+	for _, require := range cf.Requires {
+		repo, chart, version, err := ParseRef(require)
+		if err != nil {
+			return err
+		}
+		repoURL, ok := repos[repo]
+		if !ok {
+			return errors.Errorf("chart '%s' requires unknown repository '%s'", require, repo)
+		}
+		if locked, ok := lock.Get(repo, chart); ok && version == "" {
+			version = locked.Version
+		}
+
+		destDir := filepath.Join(v.chartsDir(), repo, chart)
+		digest, resolvedVersion, err := downloadChart(repoURL, chart, version, destDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to vendor chart '%s'", require)
+		}
+		lock.Set(LockedChart{Repo: repo, Chart: chart, Version: resolvedVersion, Digest: digest})
+	}
+
+	return lock.Save(v.lockfilePath())
+}
+
+// Add appends the given chart reference to the Chartfile's `requires:` list,
+// creating the Chartfile if it does not already exist, and registers its
+// repository if it is not already known
+func (v *Vendorer) Add(ref string, repoURL string) error {
+	cf, err := LoadChartfile(v.chartfilePath())
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+		cf = &Chartfile{}
+	}
+	repo, _, _, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, r := range cf.Repositories {
+		if r.Name == repo {
+			found = true
+			break
+		}
+	}
+	if !found && repoURL != "" {
+		cf.Repositories = append(cf.Repositories, RepositorySpec{Name: repo, URL: repoURL})
+	}
+	for _, existing := range cf.Requires {
+		if existing == ref {
+			return nil
+		}
+	}
+	cf.Requires = append(cf.Requires, ref)
+	return cf.Save(v.chartfilePath())
+}
+
+// Prune removes any vendored chart directory under charts/ that is no longer
+// referenced by the Chartfile, and drops its entry from the lock file
+func (v *Vendorer) Prune() error {
+	cf, err := LoadChartfile(v.chartfilePath())
+	if err != nil {
+		return err
+	}
+	lock, err := LoadLockfile(v.lockfilePath())
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, require := range cf.Requires {
+		repo, chart, _, err := ParseRef(require)
+		if err != nil {
+			return err
+		}
+		wanted[repo+"/"+chart] = true
+	}
+
+	remaining := []LockedChart{}
+	for _, c := range lock.Charts {
+		key := c.Repo + "/" + c.Chart
+		if wanted[key] {
+			remaining = append(remaining, c)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(v.chartsDir(), c.Repo, c.Chart)); err != nil {
+			return errors.Wrapf(err, "failed to remove vendored chart '%s'", key)
+		}
+	}
+	lock.Charts = remaining
+	return lock.Save(v.lockfilePath())
+}
+
+// ParseRef parses a chart reference of the form `repo/chart@version`; the
+// version is optional and defaults to the empty string, meaning "latest"
+func ParseRef(ref string) (repo string, chart string, version string, err error) {
+	name := ref
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		name = ref[:idx]
+		version = ref[idx+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return "", "", "", errors.Errorf("invalid chart reference '%s', expected 'repo/chart@version'", ref)
+	}
+	return parts[0], parts[1], version, nil
+}
+
+// downloadChart fetches the index file for repoURL, resolves the chart and
+// version to a tarball URL, downloads and extracts it into destDir, and
+// returns the SHA256 digest of the downloaded tarball and the resolved
+// version
+func downloadChart(repoURL string, chart string, version string, destDir string) (digest string, resolvedVersion string, err error) {
+	tarballURL, resolvedVersion, err := resolveTarballURL(repoURL, chart, version)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := http.Get(tarballURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download chart tarball '%s'", tarballURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.Errorf("failed to download chart tarball '%s': status %s", tarballURL, resp.Status)
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(resp.Body, hasher)
+
+	if err := os.RemoveAll(destDir); err != nil {
+		return "", "", errors.Wrapf(err, "failed to clean destination '%s'", destDir)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", "", errors.Wrapf(err, "failed to create destination '%s'", destDir)
+	}
+	if err := extractTarGz(reader, destDir); err != nil {
+		return "", "", errors.Wrapf(err, "failed to extract chart tarball '%s'", tarballURL)
+	}
+	// the tar reader stops at the end-of-archive marker, so read whatever is
+	// left of the body to make the digest cover the whole tarball
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return "", "", errors.Wrapf(err, "failed to download chart tarball '%s'", tarballURL)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), resolvedVersion, nil
+}
+
+// resolveTarballURL looks up the download URL and resolved version for the
+// given chart and version by querying repoURL/index.yaml. version must match
+// an index entry's version exactly; an empty version selects the first entry
+// listed in the index
+func resolveTarballURL(repoURL string, chart string, version string) (string, string, error) {
+	indexURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+	resp, err := http.Get(indexURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download repository index '%s'", indexURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.Errorf("failed to download repository index '%s': status %s", indexURL, resp.Status)
+	}
+
+	entries, err := parseIndexEntries(resp.Body, chart)
+	if err != nil {
+		return "", "", err
+	}
+	entry, err := selectVersion(entries, version)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to resolve chart '%s' version '%s'", chart, version)
+	}
+	tarballURL, err := resolveReferenceURL(repoURL, entry.url)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to resolve tarball URL '%s' against repository '%s'",
+			entry.url, repoURL)
+	}
+	return tarballURL, entry.version, nil
+}
+
+// resolveReferenceURL resolves a chart index entry's URL against the
+// repository's own base URL, mirroring Helm's repo.ResolveReferenceURL: an
+// index.yaml is allowed to list tarball URLs relative to the repo, not just
+// absolute ones
+func resolveReferenceURL(baseURL string, refURL string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid repository URL '%s'", baseURL)
+	}
+	ref, err := url.Parse(refURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid tarball URL '%s'", refURL)
+	}
+	if ref.IsAbs() {
+		return refURL, nil
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path = base.Path + "/"
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// extractTarGz extracts a gzip-compressed tar stream into destDir, stripping
+// the chart's own top-level directory component
+func extractTarGz(r io.Reader, destDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to read gzip stream")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read tar stream")
+		}
+		name := stripTopLevelDir(header.Name)
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(destDir, name)
+		if err := requireWithinDir(target, destDir); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		}
+	}
+}
+
+// requireWithinDir rejects tar entries whose resolved path escapes destDir,
+// e.g. via `../` segments left after stripTopLevelDir strips the chart's own
+// top-level directory component (a tar-slip/zip-slip attack)
+func requireWithinDir(target string, destDir string) error {
+	destDir = filepath.Clean(destDir)
+	target = filepath.Clean(target)
+	if target != destDir && !strings.HasPrefix(target, destDir+string(os.PathSeparator)) {
+		return errors.Errorf("tar entry '%s' escapes destination directory '%s'", target, destDir)
+	}
+	return nil
+}
+
+func stripTopLevelDir(name string) string {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+type indexEntry struct {
+	version string
+	url     string
+}
+
+// helmIndex is the minimal shape of a helm repository index.yaml needed to
+// resolve a chart's available versions and tarball URLs
+type helmIndex struct {
+	Entries map[string][]struct {
+		Version string   `json:"version"`
+		URLs    []string `json:"urls"`
+	} `json:"entries"`
+}
+
+// parseIndexEntries reads a helm repository index.yaml and returns the
+// available versions of the given chart, newest first as listed in the index
+func parseIndexEntries(r io.Reader, chart string) ([]indexEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read repository index")
+	}
+	idx := &helmIndex{}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, errors.Wrap(err, "failed to parse repository index")
+	}
+	versions, ok := idx.Entries[chart]
+	if !ok {
+		return nil, errors.Errorf("chart '%s' not found in repository index", chart)
+	}
+	entries := []indexEntry{}
+	for _, v := range versions {
+		if len(v.URLs) == 0 {
+			continue
+		}
+		entries = append(entries, indexEntry{version: v.Version, url: v.URLs[0]})
+	}
+	return entries, nil
+}
+
+func selectVersion(entries []indexEntry, constraint string) (indexEntry, error) {
+	if len(entries) == 0 {
+		return indexEntry{}, errors.New("no versions found")
+	}
+	if constraint == "" {
+		return entries[0], nil
+	}
+	for _, e := range entries {
+		if e.version == constraint {
+			return e, nil
+		}
+	}
+	return indexEntry{}, fmt.Errorf("no version matching '%s'", constraint)
+}
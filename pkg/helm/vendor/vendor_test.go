@@ -0,0 +1,51 @@
+package vendor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireWithinDir(t *testing.T) {
+	destDir := "/tmp/charts/stable/mysql"
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{"inside destDir", filepath.Join(destDir, "templates", "deployment.yaml"), false},
+		{"equal to destDir", destDir, false},
+		{"parent traversal", filepath.Join(destDir, "..", "..", "evil"), true},
+		{"sibling dir with shared prefix", "/tmp/charts/stable/mysql-evil/payload", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := requireWithinDir(tt.target, destDir)
+			if tt.wantErr && err == nil {
+				t.Fatalf("requireWithinDir(%q, %q) = nil, want error", tt.target, destDir)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("requireWithinDir(%q, %q) = %v, want nil", tt.target, destDir, err)
+			}
+		})
+	}
+}
+
+func TestStripTopLevelDir(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"chart file", "mysql/templates/deployment.yaml", "templates/deployment.yaml"},
+		{"top-level dir only", "mysql/", ""},
+		{"no top-level dir", "Chart.yaml", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stripTopLevelDir(tt.in)
+			if got != tt.want {
+				t.Fatalf("stripTopLevelDir(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
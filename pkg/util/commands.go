@@ -1,25 +1,50 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
 
 	"io/ioutil"
+	"sync"
 
 	"github.com/cenkalti/backoff"
 	"github.com/jenkins-x/jx/pkg/log"
 	"github.com/pkg/errors"
 )
 
+// RetryPredicate decides, given the error returned by a command invocation
+// and its combined output, whether Run should retry the command. Returning
+// false short-circuits the exponential backoff so that terminal errors (e.g.
+// "release: not found") don't waste the full retry timeout
+type RetryPredicate func(err error, output string) bool
+
 // Command is a struct containing the details of an external command to be executed
 type Command struct {
-	attempts           int
-	Errors             []error
-	Dir                string
-	Name               string
-	Args               []string
+	attempts int
+	Errors   []error
+	Dir      string
+	Name     string
+	Args     []string
+	Env      map[string]string
+	// Context, when set, makes the command cancellable/deadline-aware via
+	// exec.CommandContext instead of relying solely on ExponentialBackOff's
+	// MaxElapsedTime
+	Context context.Context
+	// Out and Err, when set, receive a copy of the command's stdout/stderr as
+	// it is produced, so callers can stream progress from long-running
+	// invocations. They are not written to when the command is quiet (see
+	// IsQuiet). The combined output is still captured and returned as
+	// before, regardless of whether these are set
+	Out io.Writer
+	Err io.Writer
+	// RetryPredicate is consulted by Run before a failed attempt is retried;
+	// a nil RetryPredicate retries every error, preserving current behaviour
+	RetryPredicate     RetryPredicate
 	ExponentialBackOff *backoff.ExponentialBackOff
 	Timeout            time.Duration
 	Verbose            bool
@@ -27,6 +52,7 @@ type Command struct {
 }
 
 // CommandInterface defines the interface for a Command
+//
 //go:generate pegomock generate github.com/jenkins-x/jx/pkg/util CommandInterface
 type CommandInterface interface {
 	DidError() bool
@@ -85,7 +111,6 @@ func (c *Command) IsQuiet() bool {
 
 // Run Execute the command and block waiting for return values
 func (c *Command) Run() (string, error) {
-	os.Setenv("PATH", PathWithBinary(c.Dir))
 	var r string
 	var e error
 
@@ -94,6 +119,9 @@ func (c *Command) Run() (string, error) {
 		c.attempts++
 		if e != nil {
 			c.Errors = append(c.Errors, e)
+			if c.RetryPredicate != nil && !c.RetryPredicate(e, r) {
+				return backoff.Permanent(e)
+			}
 			return e
 		}
 		return nil
@@ -114,7 +142,6 @@ func (c *Command) Run() (string, error) {
 
 // RunWithoutRetry Execute the command without retrying on failure and block waiting for return values
 func (c *Command) RunWithoutRetry() (string, error) {
-	os.Setenv("PATH", PathWithBinary(c.Dir))
 	var r string
 	var e error
 
@@ -127,16 +154,37 @@ func (c *Command) RunWithoutRetry() (string, error) {
 }
 
 func (c *Command) run() (string, error) {
-	e := exec.Command(c.Name, c.Args...)
+	var e *exec.Cmd
+	if c.Context != nil {
+		e = exec.CommandContext(c.Context, c.Name, c.Args...)
+	} else {
+		e = exec.Command(c.Name, c.Args...)
+	}
 	if c.Dir != "" {
 		e.Dir = c.Dir
 	}
+	e.Env = append(os.Environ(), "PATH="+PathWithBinary(c.Dir))
+	for k, v := range c.Env {
+		e.Env = append(e.Env, k+"="+v)
+	}
+
+	var buf bytes.Buffer
 	if c.IsQuiet() {
-		e.Stdout = ioutil.Discard
-		e.Stderr = ioutil.Discard
+		e.Stdout = &buf
+		e.Stderr = &buf
+	} else {
+		// buf is shared between the stdout and stderr copy goroutines exec
+		// spawns, so writes to it must be serialized via a single mutex-guarded
+		// writer; os/exec only reuses one copying goroutine when Stdout and
+		// Stderr are the *same* io.Writer value, which a bare bytes.Buffer
+		// wrapped in two separate MultiWriters is not
+		sw := &syncWriter{w: &buf}
+		e.Stdout = io.MultiWriter(sw, writerOrDiscard(c.Out))
+		e.Stderr = io.MultiWriter(sw, writerOrDiscard(c.Err))
 	}
-	data, err := e.CombinedOutput()
-	output := string(data)
+
+	err := e.Run()
+	output := buf.String()
 	text := strings.TrimSpace(output)
 	if err != nil {
 		return text, errors.Wrapf(err, "failed to run '%s %s' command in directory '%s', output: '%s'",
@@ -148,6 +196,27 @@ func (c *Command) run() (string, error) {
 	return text, err
 }
 
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return ioutil.Discard
+	}
+	return w
+}
+
+// syncWriter guards a shared io.Writer so that concurrent writers (e.g. the
+// stdout- and stderr-copy goroutines exec.Cmd spawns when Stdout and Stderr
+// are distinct io.Writer values) don't race writing to it
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
 // PathWithBinary Sets the $PATH variable. Accepts an optional slice of strings containing paths to add to $PATH
 func PathWithBinary(paths ...string) string {
 	path := os.Getenv("PATH")
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRunRetryPredicateShortCircuits verifies that a RetryPredicate
+// returning false stops Run from retrying a failing command, instead of
+// retrying for the full backoff timeout
+func TestRunRetryPredicateShortCircuits(t *testing.T) {
+	c := &Command{
+		Name:    "false",
+		Timeout: 5 * time.Second,
+		RetryPredicate: func(err error, output string) bool {
+			return false
+		},
+	}
+	_, err := c.Run()
+	if err == nil {
+		t.Fatal("expected an error from a failing command, got nil")
+	}
+	if c.Attempts() != 1 {
+		t.Fatalf("expected RetryPredicate=false to short-circuit after 1 attempt, got %d", c.Attempts())
+	}
+}
+
+// TestRunRetriesWhenPredicateAllows verifies that, absent a RetryPredicate
+// that says otherwise, Run keeps retrying a failing command until its
+// Timeout elapses
+func TestRunRetriesWhenPredicateAllows(t *testing.T) {
+	c := &Command{
+		Name:    "false",
+		Timeout: 1200 * time.Millisecond,
+	}
+	_, err := c.Run()
+	if err == nil {
+		t.Fatal("expected an error from a failing command, got nil")
+	}
+	if c.Attempts() < 2 {
+		t.Fatalf("expected more than 1 attempt without a short-circuiting RetryPredicate, got %d", c.Attempts())
+	}
+}